@@ -1,18 +1,15 @@
 package main
 
 import (
-	"errors"
+	"time"
+
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
-	"time"
+
+	"historian/backend/storage"
 )
 
-type User struct {
-	ID       int    `db:"id"`
-	Email    string `db:"email"`
-	Password string `db:"password"`
-	Role     string `db:"role"`
-}
+type User = storage.User
 
 func hashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
@@ -24,20 +21,27 @@ func checkPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func createToken(user User, jwtSecret string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":   user.ID,
-		"role": user.Role,
-		"exp":  time.Now().Add(time.Hour * 1).Unix(),
+func (a *api) createToken(user User) (string, error) {
+	return a.signToken(jwt.MapClaims{
+		"id":     user.ID,
+		"role":   user.Role,
+		"scopes": parseScopes(user.Scopes),
+		"exp":    time.Now().Add(time.Hour * 1).Unix(),
 	})
-	return token.SignedString([]byte(jwtSecret))
 }
 
-func validateToken(tokenString, jwtSecret string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return []byte(jwtSecret), nil
+// createChallengeToken mints a short-lived JWT standing in for the access
+// token while a 2FA-enabled user still owes us a TOTP code. Its "purpose"
+// claim is what currentUser checks (and rejects) to keep it from being used
+// in place of a real access token anywhere a handler expects one.
+func (a *api) createChallengeToken(user User) (string, error) {
+	return a.signToken(jwt.MapClaims{
+		"id":      user.ID,
+		"purpose": "2fa_challenge",
+		"exp":     time.Now().Add(time.Minute * 5).Unix(),
 	})
 }
+
+func (a *api) validateToken(tokenString string) (*jwt.Token, error) {
+	return a.parseAndVerifyToken(tokenString)
+}
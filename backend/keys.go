@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"historian/backend/storage"
+)
+
+const signingKeyBits = 2048
+
+// ensureSigningKey makes sure at least one RS256 signing key exists, so a
+// fresh database can mint tokens immediately. Rotation (adding a new current
+// key while keeping old ones around for JWKS/verification) happens via
+// rotateSigningKey, not here.
+func (a *api) ensureSigningKey() error {
+	count, err := a.store.CountCurrentSigningKeys()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = a.rotateSigningKey()
+	return err
+}
+
+// rotateSigningKey generates a new RSA key pair, demotes any previously
+// current key, and inserts the new one as current. Demoted keys are kept in
+// the table (and therefore still published via JWKS) so tokens signed before
+// the rotation keep validating until they expire.
+func (a *api) rotateSigningKey() (string, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return "", err
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return "", err
+	}
+	kid := hex.EncodeToString(kidBytes)
+
+	privPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	})
+	pubBytes, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+
+	if err := a.store.DemoteCurrentSigningKeys(); err != nil {
+		return "", err
+	}
+	err = a.store.CreateSigningKey(storage.SigningKey{
+		Kid:           kid,
+		PrivateKeyPEM: string(privPEM),
+		PublicKeyPEM:  string(pubPEM),
+		CreatedAt:     time.Now(),
+		IsCurrent:     true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Println("Rotated JWT signing key, new kid:", kid)
+	return kid, nil
+}
+
+type signingKey struct {
+	Kid        string
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
+}
+
+func (a *api) currentSigningKey() (*signingKey, error) {
+	k, err := a.store.CurrentSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return parseSigningKey(k.Kid, k.PrivateKeyPEM, k.PublicKeyPEM)
+}
+
+func (a *api) signingKeyByKid(kid string) (*signingKey, error) {
+	k, err := a.store.SigningKeyByKid(kid)
+	if err != nil {
+		return nil, err
+	}
+	return parseSigningKey(kid, k.PrivateKeyPEM, k.PublicKeyPEM)
+}
+
+func parseSigningKey(kid, privPEM, pubPEM string) (*signingKey, error) {
+	privBlock, _ := pem.Decode([]byte(privPEM))
+	if privBlock == nil {
+		return nil, fmt.Errorf("invalid private key PEM for kid %s", kid)
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(privBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := parsePublicKeyPEM(pubPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key PEM for kid %s: %w", kid, err)
+	}
+
+	return &signingKey{Kid: kid, PrivateKey: priv, PublicKey: pub}, nil
+}
+
+func parsePublicKeyPEM(pubPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pubPEM))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM")
+	}
+	pubAny, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := pubAny.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA public key")
+	}
+	return pub, nil
+}
+
+// activeJWKS lists every non-retired signing key's public component, in the
+// format /.well-known/jwks.json serves.
+func (a *api) activeJWKS() ([]map[string]string, error) {
+	signingKeys, err := a.store.ActiveSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []map[string]string
+	for _, sk := range signingKeys {
+		pub, err := parsePublicKeyPEM(sk.PublicKeyPEM)
+		if err != nil {
+			continue
+		}
+
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": sk.Kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	return keys, nil
+}
+
+func (a *api) signToken(claims jwt.MapClaims) (string, error) {
+	key, err := a.currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.PrivateKey)
+}
+
+func (a *api) parseAndVerifyToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("token missing kid")
+		}
+		key, err := a.signingKeyByKid(kid)
+		if err != nil {
+			return nil, err
+		}
+		return key.PublicKey, nil
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+)
+
+// Mailer sends transactional email. It exists so handlers don't depend on a
+// concrete SMTP client and tests can substitute a fake implementation.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends mail through an SMTP relay with STARTTLS, configured via
+// SMTP_HOST, SMTP_USER, SMTP_PASS, and MAIL_FROM.
+type SMTPMailer struct {
+	Host string
+	User string
+	Pass string
+	From string
+}
+
+func newSMTPMailerFromEnv() *SMTPMailer {
+	return &SMTPMailer{
+		Host: os.Getenv("SMTP_HOST"),
+		User: os.Getenv("SMTP_USER"),
+		Pass: os.Getenv("SMTP_PASS"),
+		From: os.Getenv("MAIL_FROM"),
+	}
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	host, _, err := net.SplitHostPort(m.Host)
+	if err != nil {
+		host = m.Host
+	}
+
+	auth := smtp.PlainAuth("", m.User, m.Pass, host)
+	client, err := smtp.Dial(m.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+		return err
+	}
+	if err := client.Auth(auth); err != nil {
+		return err
+	}
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.From, to, subject, body)
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
@@ -1,19 +1,25 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
-	"github.com/joho/godotenv"
 	"log"
 	"net/http"
 	"os"
-	"historian/backend/templates"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/joho/godotenv"
+
 	"github.com/gorilla/mux"
+
+	"historian/backend/storage"
+	"historian/backend/templates"
 )
 
-var db *sql.DB
+// api bundles the storage backend so handlers can be written as methods
+// instead of reaching for a package-level database handle. This is what
+// makes it possible to swap sqlite for postgres, or a fake Store in tests.
+type api struct {
+	store storage.Store
+}
 
 func main() {
 	err := godotenv.Load()
@@ -21,26 +27,24 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	// Connect to SQLite database
-	db, err = sql.Open("sqlite3", "./video_compilation.db")
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		databaseURL = "sqlite://./video_compilation.db"
+	}
+	store, err := storage.Open(databaseURL)
 	if err != nil {
 		log.Fatal("Error connecting to database:", err)
 	}
-	defer db.Close()
-
-	// Create users table if it doesn't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			email TEXT UNIQUE NOT NULL,
-			password TEXT NOT NULL,
-			role TEXT NOT NULL DEFAULT 'user'
-		);
-	`)
-	if err != nil {
-		log.Fatal("Error creating users table:", err)
+	defer store.Close()
+
+	a := &api{store: store}
+
+	if err := a.ensureSigningKey(); err != nil {
+		log.Fatal("Error provisioning JWT signing key:", err)
 	}
 
+	mailer = newSMTPMailerFromEnv()
+
 	r := mux.NewRouter()
 
 	// Serve static files
@@ -51,41 +55,52 @@ func main() {
 		templates.Login().Render(r.Context(), w)
 	}).Methods("GET")
 
-  r.Handle("/admin", authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-      users := getUsers()
-      templates.Admin(users).Render(r.Context(), w)
-  }))).Methods("GET")
-
-	r.HandleFunc("/api/auth/setup", setupAdmin).Methods("POST")
-	r.HandleFunc("/api/auth/login", login).Methods("POST")
-	r.HandleFunc("/api/auth/users", addUser).Methods("POST")
+	r.Handle("/admin", a.RequireScope("users:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		users := a.getUsers()
+		templates.Admin(users).Render(r.Context(), w)
+	}))).Methods("GET")
+
+	r.HandleFunc("/api/auth/setup", a.setupAdmin).Methods("POST")
+	r.HandleFunc("/api/auth/login", a.login).Methods("POST")
+	r.Handle("/api/auth/users", a.RequireScope("users:write")(http.HandlerFunc(a.addUser))).Methods("POST")
+	r.Handle("/api/auth/users/{id}/scopes", a.RequireScope("users:write")(http.HandlerFunc(a.updateUserScopes))).Methods("PATCH")
+
+	// enrollTotp/verifyTotp authenticate the caller themselves via
+	// currentUser, so they're wired up directly rather than behind a
+	// middleware wrapper.
+	r.HandleFunc("/api/auth/2fa/enroll", a.enrollTotp).Methods("POST")
+	r.HandleFunc("/api/auth/2fa/verify", a.verifyTotp).Methods("POST")
+	r.HandleFunc("/api/auth/2fa/challenge", a.challengeTotp).Methods("POST")
+
+	r.HandleFunc("/api/auth/refresh", a.refreshAccessToken).Methods("POST")
+	r.HandleFunc("/api/auth/logout", a.logout).Methods("POST")
+	// logoutAll also authenticates the caller itself via currentUser.
+	r.HandleFunc("/api/auth/logout-all", a.logoutAll).Methods("POST")
+
+	r.HandleFunc("/api/auth/password/forgot", a.forgotPassword).Methods("POST")
+	r.HandleFunc("/api/auth/password/reset", a.resetPassword).Methods("POST")
+
+	r.Handle("/api/auth/oauth/clients", a.RequireScope("users:write")(http.HandlerFunc(a.registerOAuthClient))).Methods("POST")
+	r.HandleFunc("/oauth/authorize", a.oauthAuthorize).Methods("GET", "POST")
+	r.HandleFunc("/oauth/token", a.oauthToken).Methods("POST")
+	r.HandleFunc("/oauth/userinfo", a.oauthUserinfo).Methods("GET")
+	r.HandleFunc("/.well-known/openid-configuration", oidcConfiguration).Methods("GET")
+	r.HandleFunc("/.well-known/jwks.json", a.jwksHandler).Methods("GET")
 
 	log.Println("Server started on :8080")
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
 
-func getUsers() []User {
-	var users []User
-	rows, err := db.Query("SELECT id, email, role FROM users")
+func (a *api) getUsers() []User {
+	users, err := a.store.ListUsers()
 	if err != nil {
 		log.Println("Error fetching users:", err)
-		return users
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.ID, &user.Email, &user.Role)
-		if err != nil {
-			log.Println("Error scanning user:", err)
-			continue
-		}
-		users = append(users, user)
+		return nil
 	}
 	return users
 }
 
-func setupAdmin(w http.ResponseWriter, r *http.Request) {
+func (a *api) setupAdmin(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -106,7 +121,7 @@ func setupAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO users (email, password, role) VALUES (?, ?, ?)", req.Email, hashedPassword, "admin")
+	_, err = a.store.CreateUser(User{Email: req.Email, Password: hashedPassword, Role: "admin", Scopes: defaultScopesForRole("admin")})
 	if err != nil {
 		http.Error(w, "Error creating admin user", http.StatusInternalServerError)
 		return
@@ -116,7 +131,7 @@ func setupAdmin(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Admin user created"})
 }
 
-func login(w http.ResponseWriter, r *http.Request) {
+func (a *api) login(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -126,8 +141,7 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user User
-	err := db.QueryRow("SELECT id, email, password, role FROM users WHERE email = ?", req.Email).Scan(&user.ID, &user.Email, &user.Password, &user.Role)
+	user, err := a.store.FindUserByEmail(req.Email)
 	if err != nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
@@ -138,16 +152,26 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := createToken(user, os.Getenv("JWT_SECRET"))
-	if err != nil {
-		http.Error(w, "Error creating token", http.StatusInternalServerError)
+	if user.TotpConfirmed {
+		challengeToken, err := a.createChallengeToken(*user)
+		if err != nil {
+			http.Error(w, "Error creating challenge", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"otp_required":    true,
+			"challenge_token": challengeToken,
+		})
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	if err := a.issueTokenPair(w, r, *user, nil); err != nil {
+		http.Error(w, "Error creating token", http.StatusInternalServerError)
+		return
+	}
 }
 
-func addUser(w http.ResponseWriter, r *http.Request) {
+func (a *api) addUser(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -163,7 +187,7 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO users (email, password) VALUES (?, ?)", req.Email, hashedPassword)
+	_, err = a.store.CreateUser(User{Email: req.Email, Password: hashedPassword, Role: "user", Scopes: defaultScopesForRole("user")})
 	if err != nil {
 		http.Error(w, "Error creating user", http.StatusInternalServerError)
 		return
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"historian/backend/storage"
+)
+
+const (
+	oauthCodeTTL        = 60 * time.Second
+	oauthAccessTokenTTL = time.Hour
+)
+
+func oidcIssuer() string {
+	if iss := os.Getenv("OIDC_ISSUER"); iss != "" {
+		return iss
+	}
+	return "http://localhost:8080"
+}
+
+// registerOAuthClient lets an admin (RequireScope("users:write")) onboard a
+// third-party client. The plaintext client secret is only ever returned once.
+func (a *api) registerOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	clientID, err := randomURLSafeToken(16)
+	if err != nil {
+		http.Error(w, "Error creating client", http.StatusInternalServerError)
+		return
+	}
+	clientSecret, err := randomURLSafeToken(32)
+	if err != nil {
+		http.Error(w, "Error creating client", http.StatusInternalServerError)
+		return
+	}
+	secretHash, err := hashPassword(clientSecret)
+	if err != nil {
+		http.Error(w, "Error creating client", http.StatusInternalServerError)
+		return
+	}
+
+	err = a.store.CreateOAuthClient(storage.OAuthClient{
+		ClientID:         clientID,
+		ClientSecretHash: secretHash,
+		RedirectURIs:     strings.Join(req.RedirectURIs, ","),
+		AllowedScopes:    strings.Join(req.Scopes, ","),
+		Name:             req.Name,
+	})
+	if err != nil {
+		http.Error(w, "Error creating client", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"client_id":     clientID,
+		"client_secret": clientSecret,
+	})
+}
+
+type oauthClient struct {
+	ClientID         string
+	ClientSecretHash string
+	RedirectURIs     []string
+	AllowedScopes    []string
+	Name             string
+}
+
+func (a *api) lookupOAuthClient(clientID string) (*oauthClient, error) {
+	c, err := a.store.FindOAuthClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	return &oauthClient{
+		ClientID:         c.ClientID,
+		ClientSecretHash: c.ClientSecretHash,
+		RedirectURIs:     strings.Split(c.RedirectURIs, ","),
+		AllowedScopes:    strings.Split(c.AllowedScopes, ","),
+		Name:             c.Name,
+	}, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// oauthAuthorize implements the authorization_code + PKCE (S256 only) grant.
+// This module has no general-purpose browser session of its own, so a third
+// party's top-level redirect here (which can't carry an Authorization
+// header) is met with a minimal login form on GET; the form POSTs back to
+// this same URL (query string and all), and resolveAuthorizeUser treats that
+// as the resource owner logging in. A caller that already holds a bearer
+// token (e.g. an app embedding a webview) can skip the form by setting
+// Authorization on the GET instead.
+//
+// Known limitation: the login form only handles password auth, not
+// TOTP-enabled accounts.
+func (a *api) oauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" {
+		http.Error(w, "unsupported_response_type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid_request: code_challenge_method must be S256", http.StatusBadRequest)
+		return
+	}
+	challenge := q.Get("code_challenge")
+	if len(challenge) == 0 {
+		http.Error(w, "invalid_request: missing code_challenge", http.StatusBadRequest)
+		return
+	}
+
+	client, err := a.lookupOAuthClient(q.Get("client_id"))
+	if err != nil {
+		http.Error(w, "invalid_client", http.StatusBadRequest)
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	if !contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "invalid_request: redirect_uri not registered", http.StatusBadRequest)
+		return
+	}
+
+	requestedScopes := strings.Fields(strings.ReplaceAll(q.Get("scope"), ",", " "))
+	for _, s := range requestedScopes {
+		if !contains(client.AllowedScopes, s) {
+			http.Error(w, "invalid_scope", http.StatusBadRequest)
+			return
+		}
+	}
+
+	user, err := a.resolveAuthorizeUser(r)
+	if err != nil {
+		loginErr := ""
+		if r.Method == http.MethodPost {
+			loginErr = "Invalid email or password."
+		}
+		a.renderAuthorizeLogin(w, r, client.Name, loginErr)
+		return
+	}
+
+	code, err := randomURLSafeToken(32)
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+
+	err = a.store.CreateOAuthCode(storage.OAuthCode{
+		Code:          code,
+		ClientID:      client.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   redirectURI,
+		Scopes:        strings.Join(requestedScopes, ","),
+		CodeChallenge: challenge,
+		Nonce:         q.Get("nonce"),
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+
+	dest, err := appendRedirectParams(redirectURI, code, q.Get("state"))
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, dest, http.StatusFound)
+}
+
+// appendRedirectParams adds code (and state, if present) to redirectURI's
+// query string, merging with whatever query parameters the client already
+// registered the redirect_uri with (RFC 6749 allows a redirect_uri to carry
+// its own query string, e.g. "https://app.example/callback?tenant=1").
+func appendRedirectParams(redirectURI, code, state string) (string, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("code", code)
+	if state != "" {
+		query.Set("state", state)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// resolveAuthorizeUser identifies the resource owner for oauthAuthorize: a
+// POST (the login form submitting) authenticates by email/password, while a
+// GET falls back to an already-held bearer token.
+func (a *api) resolveAuthorizeUser(r *http.Request) (*User, error) {
+	if r.Method != http.MethodPost {
+		return a.currentUser(r)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	user, err := a.store.FindUserByEmail(r.PostForm.Get("email"))
+	if err != nil {
+		return nil, err
+	}
+	if !checkPasswordHash(r.PostForm.Get("password"), user.Password) {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	if user.TotpConfirmed {
+		return nil, fmt.Errorf("2fa-enabled accounts aren't supported by this login form")
+	}
+	return user, nil
+}
+
+// renderAuthorizeLogin serves the minimal login form a browser lands on when
+// it can't present a bearer token directly. It POSTs back to the exact same
+// URL (including the original authorization request's query string) so the
+// grant can resume once resolveAuthorizeUser has a user to issue a code for.
+func (a *api) renderAuthorizeLogin(w http.ResponseWriter, r *http.Request, clientName, loginErr string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if loginErr != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	errHTML := ""
+	if loginErr != "" {
+		errHTML = "<p>" + html.EscapeString(loginErr) + "</p>"
+	}
+
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><title>Sign in</title></head>
+<body>
+<h1>Sign in to continue to %s</h1>
+%s
+<form method="POST" action="%s">
+<label>Email <input type="email" name="email" required></label><br>
+<label>Password <input type="password" name="password" required></label><br>
+<button type="submit">Sign in &amp; authorize</button>
+</form>
+</body>
+</html>
+`, html.EscapeString(clientName), errHTML, html.EscapeString(r.URL.String()))
+}
+
+func (a *api) oauthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		http.Error(w, "unsupported_grant_type", http.StatusBadRequest)
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	clientSecret := r.PostForm.Get("client_secret")
+	client, err := a.lookupOAuthClient(clientID)
+	if err != nil || !checkPasswordHash(clientSecret, client.ClientSecretHash) {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	oc, err := a.store.FindOAuthCode(code, clientID)
+	if err != nil {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	if oc.UsedAt != nil || time.Now().After(oc.ExpiresAt) {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	if oc.RedirectURI != r.PostForm.Get("redirect_uri") {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	verifier := r.PostForm.Get("code_verifier")
+	if len(verifier) < 43 || len(verifier) > 128 || !pkceMatches(verifier, oc.CodeChallenge) {
+		http.Error(w, "invalid_grant: PKCE verification failed", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := a.store.MarkOAuthCodeUsed(code)
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// Lost the race with a concurrent redemption of the same code.
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	user, err := a.store.FindUserByID(oc.UserID)
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := a.signToken(jwt.MapClaims{
+		"iss":    oidcIssuer(),
+		"sub":    user.ID,
+		"aud":    clientID,
+		"scopes": strings.Split(oc.Scopes, ","),
+		"exp":    time.Now().Add(oauthAccessTokenTTL).Unix(),
+		"iat":    time.Now().Unix(),
+	})
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+
+	idTokenClaims := jwt.MapClaims{
+		"iss":   oidcIssuer(),
+		"sub":   user.ID,
+		"aud":   clientID,
+		"email": user.Email,
+		"exp":   time.Now().Add(oauthAccessTokenTTL).Unix(),
+		"iat":   time.Now().Unix(),
+	}
+	if oc.Nonce != "" {
+		idTokenClaims["nonce"] = oc.Nonce
+	}
+	idToken, err := a.signToken(idTokenClaims)
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, err := a.createRefreshToken(user.ID, r, nil)
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  accessToken,
+		"id_token":      idToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(oauthAccessTokenTTL.Seconds()),
+	})
+}
+
+func (a *api) oauthUserinfo(w http.ResponseWriter, r *http.Request) {
+	user, err := a.currentUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sub":   user.ID,
+		"email": user.Email,
+	})
+}
+
+func oidcConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := oidcIssuer()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (a *api) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.activeJWKS()
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func pkceMatches(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+func randomURLSafeToken(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
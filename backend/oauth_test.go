@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"historian/backend/storage"
+)
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// setupOAuthCode registers a client and an outstanding authorization code
+// bound to codeChallenge, bypassing oauthAuthorize so the /oauth/token tests
+// don't depend on the login step.
+func setupOAuthCode(t *testing.T, a *api, user User, codeChallenge string) (clientID, clientSecret, code string) {
+	t.Helper()
+
+	clientID = "client-1"
+	clientSecret = "s3cret"
+	secretHash, err := hashPassword(clientSecret)
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if err := a.store.CreateOAuthClient(storage.OAuthClient{
+		ClientID:         clientID,
+		ClientSecretHash: secretHash,
+		RedirectURIs:     "https://client.example/callback",
+		AllowedScopes:    "videos:read",
+		Name:             "Test Client",
+	}); err != nil {
+		t.Fatalf("CreateOAuthClient: %v", err)
+	}
+
+	code = "test-auth-code"
+	if err := a.store.CreateOAuthCode(storage.OAuthCode{
+		Code:          code,
+		ClientID:      clientID,
+		UserID:        user.ID,
+		RedirectURI:   "https://client.example/callback",
+		Scopes:        "videos:read",
+		CodeChallenge: codeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	}); err != nil {
+		t.Fatalf("CreateOAuthCode: %v", err)
+	}
+	return clientID, clientSecret, code
+}
+
+func tokenRequest(clientID, clientSecret, code, verifier string) *http.Request {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"code":          {code},
+		"redirect_uri":  {"https://client.example/callback"},
+		"code_verifier": {verifier},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// TestOAuthTokenRejectsWrongVerifier is the security-critical PKCE path: a
+// client presenting the wrong code_verifier for the code_challenge it sent
+// to /oauth/authorize must not get tokens back.
+func TestOAuthTokenRejectsWrongVerifier(t *testing.T) {
+	a := newTestAPI(t)
+	user := createTestUser(t, a, "user")
+
+	verifier := strings.Repeat("a", 43)
+	clientID, clientSecret, code := setupOAuthCode(t, a, user, pkceChallenge(verifier))
+
+	w := httptest.NewRecorder()
+	a.oauthToken(w, tokenRequest(clientID, clientSecret, code, strings.Repeat("b", 43)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for mismatched verifier, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestOAuthTokenAcceptsCorrectVerifier is the matching happy path.
+func TestOAuthTokenAcceptsCorrectVerifier(t *testing.T) {
+	a := newTestAPI(t)
+	user := createTestUser(t, a, "user")
+
+	verifier := strings.Repeat("a", 43)
+	clientID, clientSecret, code := setupOAuthCode(t, a, user, pkceChallenge(verifier))
+
+	w := httptest.NewRecorder()
+	a.oauthToken(w, tokenRequest(clientID, clientSecret, code, verifier))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for matching verifier, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func registerTestOAuthClient(t *testing.T, a *api) (clientID string) {
+	t.Helper()
+	clientID = "authorize-client"
+	secretHash, err := hashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if err := a.store.CreateOAuthClient(storage.OAuthClient{
+		ClientID:         clientID,
+		ClientSecretHash: secretHash,
+		RedirectURIs:     "https://client.example/callback",
+		AllowedScopes:    "videos:read",
+		Name:             "Test Client",
+	}); err != nil {
+		t.Fatalf("CreateOAuthClient: %v", err)
+	}
+	return clientID
+}
+
+func authorizeURL(clientID string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {"https://client.example/callback"},
+		"code_challenge":        {pkceChallenge(strings.Repeat("a", 43))},
+		"code_challenge_method": {"S256"},
+		"scope":                 {"videos:read"},
+	}
+	return "/oauth/authorize?" + v.Encode()
+}
+
+// TestOAuthAuthorizeGetWithoutBearerShowsLoginForm covers the gap a browser
+// hits on a top-level redirect from a third-party app: it can't attach an
+// Authorization header, so it must see a login form instead of a bare 401.
+func TestOAuthAuthorizeGetWithoutBearerShowsLoginForm(t *testing.T) {
+	a := newTestAPI(t)
+	clientID := registerTestOAuthClient(t, a)
+
+	w := httptest.NewRecorder()
+	a.oauthAuthorize(w, httptest.NewRequest(http.MethodGet, authorizeURL(clientID), nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 rendering the login form, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "<form") {
+		t.Fatalf("expected a login form in the response body, got: %s", w.Body.String())
+	}
+}
+
+// TestOAuthAuthorizePostLoginCompletesGrant exercises the form submission
+// this fix adds: a POST with valid credentials should redirect to the
+// client's redirect_uri with an authorization code, same as a valid bearer
+// token would.
+func TestOAuthAuthorizePostLoginCompletesGrant(t *testing.T) {
+	a := newTestAPI(t)
+	clientID := registerTestOAuthClient(t, a)
+
+	hashed, err := hashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if _, err := a.store.CreateUser(User{
+		Email:    "owner@example.com",
+		Password: hashed,
+		Role:     "user",
+		Scopes:   defaultScopesForRole("user"),
+	}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	form := url.Values{"email": {"owner@example.com"}, "password": {"correct-horse"}}
+	r := httptest.NewRequest(http.MethodPost, authorizeURL(clientID), strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	a.oauthAuthorize(w, r)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302 redirect with an authorization code, got %d: %s", w.Code, w.Body.String())
+	}
+	loc := w.Header().Get("Location")
+	if !strings.Contains(loc, "https://client.example/callback?code=") {
+		t.Fatalf("expected redirect to carry an authorization code, got %q", loc)
+	}
+}
+
+// TestOAuthTokenRejectsReplayedCode covers the other half of code-redemption
+// safety: a code can only be exchanged once, even with a valid verifier.
+func TestOAuthTokenRejectsReplayedCode(t *testing.T) {
+	a := newTestAPI(t)
+	user := createTestUser(t, a, "user")
+
+	verifier := strings.Repeat("a", 43)
+	clientID, clientSecret, code := setupOAuthCode(t, a, user, pkceChallenge(verifier))
+
+	w := httptest.NewRecorder()
+	a.oauthToken(w, tokenRequest(clientID, clientSecret, code, verifier))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected first redemption to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w2 := httptest.NewRecorder()
+	a.oauthToken(w2, tokenRequest(clientID, clientSecret, code, verifier))
+	if w2.Code != http.StatusBadRequest {
+		t.Fatalf("expected replayed code to be rejected, got %d", w2.Code)
+	}
+}
+
+// TestOAuthAuthorizeRedirectPreservesExistingQuery covers a redirect_uri
+// registered with its own query string (RFC 6749 allows this). code and
+// state must be merged into it, not blindly appended after a second "?".
+func TestOAuthAuthorizeRedirectPreservesExistingQuery(t *testing.T) {
+	a := newTestAPI(t)
+
+	clientID := "tenant-client"
+	secretHash, err := hashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if err := a.store.CreateOAuthClient(storage.OAuthClient{
+		ClientID:         clientID,
+		ClientSecretHash: secretHash,
+		RedirectURIs:     "https://app.example/callback?tenant=1",
+		AllowedScopes:    "videos:read",
+		Name:             "Tenant Client",
+	}); err != nil {
+		t.Fatalf("CreateOAuthClient: %v", err)
+	}
+
+	hashed, err := hashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if _, err := a.store.CreateUser(User{
+		Email:    "owner@example.com",
+		Password: hashed,
+		Role:     "user",
+		Scopes:   defaultScopesForRole("user"),
+	}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {"https://app.example/callback?tenant=1"},
+		"code_challenge":        {pkceChallenge(strings.Repeat("a", 43))},
+		"code_challenge_method": {"S256"},
+		"scope":                 {"videos:read"},
+		"state":                 {"xyz"},
+	}
+
+	form := url.Values{"email": {"owner@example.com"}, "password": {"correct-horse"}}
+	r := httptest.NewRequest(http.MethodPost, "/oauth/authorize?"+v.Encode(), strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	a.oauthAuthorize(w, r)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302 redirect, got %d: %s", w.Code, w.Body.String())
+	}
+	loc := w.Header().Get("Location")
+	parsed, err := url.Parse(loc)
+	if err != nil {
+		t.Fatalf("redirect Location isn't a valid URL: %v (%q)", err, loc)
+	}
+	q := parsed.Query()
+	if q.Get("tenant") != "1" {
+		t.Fatalf("expected the client's own tenant=1 query param to survive, got %q", loc)
+	}
+	if q.Get("code") == "" {
+		t.Fatalf("expected an authorization code in the redirect, got %q", loc)
+	}
+	if q.Get("state") != "xyz" {
+		t.Fatalf("expected state to round-trip, got %q", loc)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"historian/backend/storage"
+)
+
+const passwordResetTTL = 15 * time.Minute
+
+var (
+	mailer Mailer
+
+	forgotPasswordByEmail = newRateLimiter(5, 15*time.Minute)
+	forgotPasswordByIP    = newRateLimiter(20, 15*time.Minute)
+	resetPasswordByIP     = newRateLimiter(20, 15*time.Minute)
+)
+
+func (a *api) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Always 202, even when rate-limited or the email doesn't exist, so the
+	// response can't be used to enumerate registered accounts.
+	defer w.WriteHeader(http.StatusAccepted)
+
+	if !forgotPasswordByEmail.Allow(req.Email) || !forgotPasswordByIP.Allow(clientIP(r)) {
+		return
+	}
+
+	user, err := a.store.FindUserByEmail(req.Email)
+	if err != nil {
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	hash := hashOpaqueToken(token)
+
+	err = a.store.CreatePasswordReset(storage.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return
+	}
+
+	if mailer == nil {
+		return
+	}
+	body := fmt.Sprintf("Use this token to reset your password (expires in 15 minutes): %s", token)
+	mailer.Send(req.Email, "Reset your password", body)
+}
+
+func (a *api) resetPassword(w http.ResponseWriter, r *http.Request) {
+	if !resetPasswordByIP.Allow(clientIP(r)) {
+		http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	reset, err := a.store.FindPasswordResetByHash(hashOpaqueToken(req.Token))
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if time.Now().After(reset.ExpiresAt) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := a.store.MarkPasswordResetUsed(reset.ID)
+	if err != nil {
+		http.Error(w, "Error resetting password", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// Lost the race with a concurrent reset of the same token.
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := hashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, "Error resetting password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := a.store.UpdateUserPassword(reset.UserID, hashedPassword); err != nil {
+		http.Error(w, "Error resetting password", http.StatusInternalServerError)
+		return
+	}
+
+	a.revokeTokenChain(reset.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
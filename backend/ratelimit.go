@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a simple sliding-window limiter keyed by an arbitrary string
+// (an email address, an IP, or a combination of the two). It's in-process
+// only, which is fine for the single-instance deployment this module runs
+// as today.
+type rateLimiter struct {
+	mu       sync.Mutex
+	window   time.Duration
+	limit    int
+	attempts map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		window:   window,
+		limit:    limit,
+		attempts: make(map[string][]time.Time),
+	}
+}
+
+// Allow records an attempt for key and reports whether it is within limit
+// attempts in the trailing window.
+func (rl *rateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-rl.window)
+
+	kept := rl.attempts[key][:0]
+	for _, t := range rl.attempts[key] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+
+	if len(kept) >= rl.limit {
+		rl.attempts[key] = kept
+		return false
+	}
+
+	rl.attempts[key] = append(kept, now)
+	return true
+}
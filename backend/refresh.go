@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"historian/backend/storage"
+)
+
+const (
+	refreshTokenCookie = "refresh_token"
+	refreshTokenTTL    = 30 * 24 * time.Hour
+	accessTokenTTL     = time.Hour
+)
+
+// issueTokenPair mints a new access token plus a rotated refresh token for
+// user, sets the refresh token as an HttpOnly cookie, and writes the JSON
+// response body expected by callers of /api/auth/login and /api/auth/refresh.
+func (a *api) issueTokenPair(w http.ResponseWriter, r *http.Request, user User, parentID *int) error {
+	accessToken, err := a.createToken(user)
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := a.createRefreshToken(user.ID, r, parentID)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    refreshToken,
+		Path:     "/api/auth",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Now().Add(refreshTokenTTL),
+	})
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+	})
+}
+
+// createRefreshToken generates a new opaque refresh token, stores its hash,
+// and links it to parentID when this call is a rotation of an existing chain.
+func (a *api) createRefreshToken(userID int, r *http.Request, parentID *int) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	hash := hashOpaqueToken(token)
+
+	_, err := a.store.CreateRefreshToken(storage.RefreshToken{
+		UserID:    userID,
+		TokenHash: hash,
+		ParentID:  parentID,
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// revokeTokenChain revokes every outstanding refresh token for userID. It is
+// invoked on reuse detection: presenting an already-revoked refresh token
+// means the token was stolen, so the entire chain it belongs to is no longer
+// trustworthy.
+func (a *api) revokeTokenChain(userID int) error {
+	return a.store.RevokeAllRefreshTokens(userID)
+}
+
+func (a *api) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(refreshTokenCookie)
+	if err != nil {
+		http.Error(w, "Missing refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	row, err := a.store.FindRefreshTokenByHash(hashOpaqueToken(cookie.Value))
+	if err != nil {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if row.RevokedAt != nil {
+		// Reuse of a revoked token: treat it as compromised and burn the
+		// whole chain so a stolen token can't be replayed later either.
+		a.revokeTokenChain(row.UserID)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if time.Now().After(row.ExpiresAt) {
+		http.Error(w, "Refresh token expired", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := a.store.FindUserByID(row.UserID)
+	if err != nil {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if err := a.store.RevokeRefreshToken(row.ID); err != nil {
+		http.Error(w, "Error rotating refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	parentID := row.ID
+	if err := a.issueTokenPair(w, r, *user, &parentID); err != nil {
+		http.Error(w, "Error issuing tokens", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *api) logout(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(refreshTokenCookie)
+	if err == nil {
+		if row, err := a.store.FindRefreshTokenByHash(hashOpaqueToken(cookie.Value)); err == nil {
+			a.store.RevokeRefreshToken(row.ID)
+		}
+	}
+	clearRefreshCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) logoutAll(w http.ResponseWriter, r *http.Request) {
+	user, err := a.currentUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := a.revokeTokenChain(user.ID); err != nil {
+		http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+		return
+	}
+	clearRefreshCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func clearRefreshCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    "",
+		Path:     "/api/auth",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"historian/backend/storage"
+)
+
+func newTestAPI(t *testing.T) *api {
+	t.Helper()
+	a := &api{store: storage.NewFake()}
+	if err := a.ensureSigningKey(); err != nil {
+		t.Fatalf("ensureSigningKey: %v", err)
+	}
+	return a
+}
+
+func createTestUser(t *testing.T, a *api, role string) User {
+	t.Helper()
+	id, err := a.store.CreateUser(User{
+		Email:  "user@example.com",
+		Role:   role,
+		Scopes: defaultScopesForRole(role),
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	user, err := a.store.FindUserByID(id)
+	if err != nil {
+		t.Fatalf("FindUserByID: %v", err)
+	}
+	return *user
+}
+
+func refreshRequest(cookieValue string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+	r.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: cookieValue})
+	return r
+}
+
+// TestRefreshRotatesToken checks the happy path: presenting a valid refresh
+// token rotates it and responds with a new pair.
+func TestRefreshRotatesToken(t *testing.T) {
+	a := newTestAPI(t)
+	user := createTestUser(t, a, "user")
+
+	original, err := a.createRefreshToken(user.ID, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil), nil)
+	if err != nil {
+		t.Fatalf("createRefreshToken: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	a.refreshAccessToken(w, refreshRequest(original))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	row, err := a.store.FindRefreshTokenByHash(hashOpaqueToken(original))
+	if err != nil {
+		t.Fatalf("FindRefreshTokenByHash: %v", err)
+	}
+	if row.RevokedAt == nil {
+		t.Fatal("expected original refresh token to be revoked after rotation")
+	}
+}
+
+// TestRefreshReuseRevokesChain is the security-critical path: replaying an
+// already-rotated (and therefore revoked) refresh token must be rejected and
+// must burn every other outstanding token for that user, since reuse implies
+// the token was stolen.
+func TestRefreshReuseRevokesChain(t *testing.T) {
+	a := newTestAPI(t)
+	user := createTestUser(t, a, "user")
+
+	original, err := a.createRefreshToken(user.ID, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil), nil)
+	if err != nil {
+		t.Fatalf("createRefreshToken: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	a.refreshAccessToken(w, refreshRequest(original))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected first rotation to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	var rotated struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &rotated); err != nil {
+		t.Fatalf("decode rotation response: %v", err)
+	}
+
+	// Replay the original (now-revoked) token, as a stolen-token attacker
+	// racing the legitimate client would.
+	w2 := httptest.NewRecorder()
+	a.refreshAccessToken(w2, refreshRequest(original))
+	if w2.Code != http.StatusUnauthorized {
+		t.Fatalf("expected reuse to be rejected with 401, got %d", w2.Code)
+	}
+
+	rotatedRow, err := a.store.FindRefreshTokenByHash(hashOpaqueToken(rotated.RefreshToken))
+	if err != nil {
+		t.Fatalf("FindRefreshTokenByHash(rotated): %v", err)
+	}
+	if rotatedRow.RevokedAt == nil {
+		t.Fatal("expected reuse of a revoked token to burn the rest of the chain too")
+	}
+}
@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory Store for tests. It gives handler tests a way to
+// exercise reuse-detection, PKCE, and other security-sensitive paths without
+// a real sqlite/postgres connection. It is not used outside tests.
+type Fake struct {
+	mu sync.Mutex
+
+	users          []User
+	recoveryCodes  []RecoveryCode
+	refreshTokens  []RefreshToken
+	passwordResets []PasswordReset
+	signingKeys    []SigningKey
+	oauthClients   []OAuthClient
+	oauthCodes     []OAuthCode
+
+	nextUserID       int
+	nextRecoveryID   int
+	nextRefreshID    int
+	nextResetID      int
+	nextSigningKeyID int
+}
+
+// NewFake returns an empty Fake Store, ready to use.
+func NewFake() *Fake {
+	return &Fake{
+		nextUserID:       1,
+		nextRecoveryID:   1,
+		nextRefreshID:    1,
+		nextResetID:      1,
+		nextSigningKeyID: 1,
+	}
+}
+
+func (f *Fake) Close() error { return nil }
+
+func (f *Fake) FindUserByEmail(email string) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Email == email {
+			u := u
+			return &u, nil
+		}
+	}
+	return nil, fmt.Errorf("user not found")
+}
+
+func (f *Fake) FindUserByID(id int) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.ID == id {
+			u := u
+			return &u, nil
+		}
+	}
+	return nil, fmt.Errorf("user not found")
+}
+
+func (f *Fake) CreateUser(user User) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Email == user.Email {
+			return 0, fmt.Errorf("email already registered")
+		}
+	}
+	user.ID = f.nextUserID
+	f.nextUserID++
+	f.users = append(f.users, user)
+	return user.ID, nil
+}
+
+func (f *Fake) ListUsers() ([]User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]User, len(f.users))
+	copy(out, f.users)
+	return out, nil
+}
+
+func (f *Fake) UpdateUser(user User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, u := range f.users {
+		if u.ID == user.ID {
+			f.users[i] = user
+			return nil
+		}
+	}
+	return fmt.Errorf("user not found")
+}
+
+func (f *Fake) UpdateUserPassword(id int, passwordHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, u := range f.users {
+		if u.ID == id {
+			f.users[i].Password = passwordHash
+			return nil
+		}
+	}
+	return fmt.Errorf("user not found")
+}
+
+func (f *Fake) UpdateUserScopes(id int, scopes string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, u := range f.users {
+		if u.ID == id {
+			f.users[i].Scopes = scopes
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *Fake) SetUserTotp(id int, secret string, confirmed bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, u := range f.users {
+		if u.ID == id {
+			f.users[i].TotpSecret = secret
+			f.users[i].TotpConfirmed = confirmed
+			return nil
+		}
+	}
+	return fmt.Errorf("user not found")
+}
+
+func (f *Fake) CreateRecoveryCodes(userID int, hashes []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, hash := range hashes {
+		f.recoveryCodes = append(f.recoveryCodes, RecoveryCode{
+			ID:     f.nextRecoveryID,
+			UserID: userID,
+			Hash:   hash,
+		})
+		f.nextRecoveryID++
+	}
+	return nil
+}
+
+func (f *Fake) UnusedRecoveryCodes(userID int) ([]RecoveryCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []RecoveryCode
+	for _, c := range f.recoveryCodes {
+		if c.UserID == userID && c.UsedAt == nil {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (f *Fake) MarkRecoveryCodeUsed(id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, c := range f.recoveryCodes {
+		if c.ID == id {
+			now := time.Now()
+			f.recoveryCodes[i].UsedAt = &now
+			return nil
+		}
+	}
+	return fmt.Errorf("recovery code not found")
+}
+
+func (f *Fake) CreateRefreshToken(t RefreshToken) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.ID = f.nextRefreshID
+	f.nextRefreshID++
+	f.refreshTokens = append(f.refreshTokens, t)
+	return t.TokenHash, nil
+}
+
+func (f *Fake) FindRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.refreshTokens {
+		if t.TokenHash == hash {
+			t := t
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("refresh token not found")
+}
+
+func (f *Fake) RevokeRefreshToken(id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, t := range f.refreshTokens {
+		if t.ID == id {
+			now := time.Now()
+			f.refreshTokens[i].RevokedAt = &now
+			return nil
+		}
+	}
+	return fmt.Errorf("refresh token not found")
+}
+
+func (f *Fake) RevokeAllRefreshTokens(userID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for i, t := range f.refreshTokens {
+		if t.UserID == userID && t.RevokedAt == nil {
+			f.refreshTokens[i].RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *Fake) CreatePasswordReset(pr PasswordReset) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pr.ID = f.nextResetID
+	f.nextResetID++
+	f.passwordResets = append(f.passwordResets, pr)
+	return nil
+}
+
+func (f *Fake) FindPasswordResetByHash(hash string) (*PasswordReset, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pr := range f.passwordResets {
+		if pr.TokenHash == hash && pr.UsedAt == nil {
+			pr := pr
+			return &pr, nil
+		}
+	}
+	return nil, fmt.Errorf("password reset not found")
+}
+
+func (f *Fake) MarkPasswordResetUsed(id int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, pr := range f.passwordResets {
+		if pr.ID == id && pr.UsedAt == nil {
+			now := time.Now()
+			f.passwordResets[i].UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *Fake) CreateSigningKey(k SigningKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k.ID = f.nextSigningKeyID
+	f.nextSigningKeyID++
+	f.signingKeys = append(f.signingKeys, k)
+	return nil
+}
+
+func (f *Fake) DemoteCurrentSigningKeys() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.signingKeys {
+		f.signingKeys[i].IsCurrent = false
+	}
+	return nil
+}
+
+func (f *Fake) CurrentSigningKey() (*SigningKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.signingKeys) - 1; i >= 0; i-- {
+		if f.signingKeys[i].IsCurrent {
+			k := f.signingKeys[i]
+			return &k, nil
+		}
+	}
+	return nil, fmt.Errorf("no current signing key")
+}
+
+func (f *Fake) SigningKeyByKid(kid string) (*SigningKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, k := range f.signingKeys {
+		if k.Kid == kid && k.RetiredAt == nil {
+			k := k
+			return &k, nil
+		}
+	}
+	return nil, fmt.Errorf("signing key not found")
+}
+
+func (f *Fake) ActiveSigningKeys() ([]SigningKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []SigningKey
+	for _, k := range f.signingKeys {
+		if k.RetiredAt == nil {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (f *Fake) CountCurrentSigningKeys() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, k := range f.signingKeys {
+		if k.IsCurrent {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *Fake) CreateOAuthClient(c OAuthClient) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.oauthClients = append(f.oauthClients, c)
+	return nil
+}
+
+func (f *Fake) FindOAuthClient(clientID string) (*OAuthClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.oauthClients {
+		if c.ClientID == clientID {
+			c := c
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("oauth client not found")
+}
+
+func (f *Fake) CreateOAuthCode(c OAuthCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.oauthCodes = append(f.oauthCodes, c)
+	return nil
+}
+
+func (f *Fake) FindOAuthCode(code, clientID string) (*OAuthCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.oauthCodes {
+		if c.Code == code && c.ClientID == clientID {
+			c := c
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("oauth code not found")
+}
+
+func (f *Fake) MarkOAuthCodeUsed(code string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, c := range f.oauthCodes {
+		if c.Code == code {
+			if c.UsedAt != nil {
+				return false, nil
+			}
+			now := time.Now()
+			f.oauthCodes[i].UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
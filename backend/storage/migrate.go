@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"sort"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// runMigrations applies every *.sql file under dir (in filename order) that
+// isn't already recorded in schema_migrations. It refuses to leave the
+// database half-migrated: each file runs in its own transaction, and a
+// failure aborts startup rather than letting handlers run against a schema
+// they don't expect. bindVar formats the Nth bind parameter for the caller's
+// SQL dialect ("?" for sqlite, "$1"-style for postgres).
+func runMigrations(db *sql.DB, fsys embed.FS, dir string, bindVar func(n int) string) error {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL
+		);
+	`); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading migrations: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	checkQuery := fmt.Sprintf("SELECT COUNT(*) FROM schema_migrations WHERE version = %s", bindVar(1))
+	insertQuery := fmt.Sprintf("INSERT INTO schema_migrations (version, applied_at) VALUES (%s, %s)", bindVar(1), bindVar(2))
+
+	for _, entry := range entries {
+		version := entry.Name()
+
+		var already int
+		if err := db.QueryRow(checkQuery, version).Scan(&already); err != nil {
+			return fmt.Errorf("checking migration %s: %w", version, err)
+		}
+		if already > 0 {
+			continue
+		}
+
+		contents, err := fsys.ReadFile(dir + "/" + version)
+		if err != nil {
+			return fmt.Errorf("reading migration %s: %w", version, err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("starting transaction for migration %s: %w", version, err)
+		}
+		if _, err := tx.Exec(string(contents)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %s: %w", version, err)
+		}
+		if _, err := tx.Exec(insertQuery, version, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %s: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("committing migration %s: %w", version, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import "time"
+
+// User mirrors the users table. It's the one type the main package aliases
+// (as `type User = storage.User`) since JWT claims, templates, and every
+// handler all need the same shape.
+type User struct {
+	ID            int    `db:"id"`
+	Email         string `db:"email"`
+	Password      string `db:"password"`
+	Role          string `db:"role"`
+	TotpSecret    string `db:"totp_secret"`
+	TotpConfirmed bool   `db:"totp_confirmed"`
+	Scopes        string `db:"scopes"`
+}
+
+type RecoveryCode struct {
+	ID     int
+	UserID int
+	Hash   string
+	UsedAt *time.Time
+}
+
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ParentID  *int
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	UserAgent string
+	IP        string
+}
+
+type PasswordReset struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type SigningKey struct {
+	ID            int
+	Kid           string
+	PrivateKeyPEM string
+	PublicKeyPEM  string
+	CreatedAt     time.Time
+	IsCurrent     bool
+	RetiredAt     *time.Time
+}
+
+type OAuthClient struct {
+	ID               int
+	ClientID         string
+	ClientSecretHash string
+	RedirectURIs     string
+	AllowedScopes    string
+	Name             string
+}
+
+type OAuthCode struct {
+	Code          string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scopes        string
+	CodeChallenge string
+	Nonce         string
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+}
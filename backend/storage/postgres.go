@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+)
+
+type postgresStore struct {
+	db *sql.DB
+}
+
+func openPostgres(databaseURL string) (Store, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	bindVar := func(n int) string { return fmt.Sprintf("$%d", n) }
+	if err := runMigrations(db, postgresMigrations, "migrations/postgres", bindVar); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresStore{db: db}, nil
+}
+
+func (s *postgresStore) Close() error { return s.db.Close() }
+
+func (s *postgresStore) FindUserByEmail(email string) (*User, error) {
+	var u User
+	err := s.db.QueryRow("SELECT id, email, password, role, totp_secret, totp_confirmed, scopes FROM users WHERE email = $1", email).
+		Scan(&u.ID, &u.Email, &u.Password, &u.Role, &u.TotpSecret, &u.TotpConfirmed, &u.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *postgresStore) FindUserByID(id int) (*User, error) {
+	var u User
+	err := s.db.QueryRow("SELECT id, email, password, role, totp_secret, totp_confirmed, scopes FROM users WHERE id = $1", id).
+		Scan(&u.ID, &u.Email, &u.Password, &u.Role, &u.TotpSecret, &u.TotpConfirmed, &u.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *postgresStore) CreateUser(user User) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		"INSERT INTO users (email, password, role, scopes) VALUES ($1, $2, $3, $4) RETURNING id",
+		user.Email, user.Password, user.Role, user.Scopes,
+	).Scan(&id)
+	return id, err
+}
+
+func (s *postgresStore) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT id, email, role FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *postgresStore) UpdateUser(user User) error {
+	_, err := s.db.Exec("UPDATE users SET email = $1, password = $2, role = $3, scopes = $4 WHERE id = $5",
+		user.Email, user.Password, user.Role, user.Scopes, user.ID)
+	return err
+}
+
+func (s *postgresStore) UpdateUserPassword(id int, passwordHash string) error {
+	_, err := s.db.Exec("UPDATE users SET password = $1 WHERE id = $2", passwordHash, id)
+	return err
+}
+
+func (s *postgresStore) UpdateUserScopes(id int, scopes string) (bool, error) {
+	result, err := s.db.Exec("UPDATE users SET scopes = $1 WHERE id = $2", scopes, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (s *postgresStore) SetUserTotp(id int, secret string, confirmed bool) error {
+	_, err := s.db.Exec("UPDATE users SET totp_secret = $1, totp_confirmed = $2 WHERE id = $3", secret, confirmed, id)
+	return err
+}
+
+func (s *postgresStore) CreateRecoveryCodes(userID int, hashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) UnusedRecoveryCodes(userID int) ([]RecoveryCode, error) {
+	rows, err := s.db.Query("SELECT id, user_id, code_hash FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []RecoveryCode
+	for rows.Next() {
+		var c RecoveryCode
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Hash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+func (s *postgresStore) MarkRecoveryCodeUsed(id int) error {
+	_, err := s.db.Exec("UPDATE recovery_codes SET used_at = NOW() WHERE id = $1", id)
+	return err
+}
+
+func (s *postgresStore) CreateRefreshToken(t RefreshToken) (string, error) {
+	_, err := s.db.Exec(
+		`INSERT INTO refresh_tokens (user_id, token_hash, parent_id, issued_at, expires_at, user_agent, ip)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		t.UserID, t.TokenHash, t.ParentID, t.IssuedAt, t.ExpiresAt, t.UserAgent, t.IP,
+	)
+	return t.TokenHash, err
+}
+
+func (s *postgresStore) FindRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	var t RefreshToken
+	err := s.db.QueryRow(
+		"SELECT id, user_id, token_hash, parent_id, issued_at, expires_at, revoked_at, user_agent, ip FROM refresh_tokens WHERE token_hash = $1",
+		hash,
+	).Scan(&t.ID, &t.UserID, &t.TokenHash, &t.ParentID, &t.IssuedAt, &t.ExpiresAt, &t.RevokedAt, &t.UserAgent, &t.IP)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *postgresStore) RevokeRefreshToken(id int) error {
+	_, err := s.db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1", id)
+	return err
+}
+
+func (s *postgresStore) RevokeAllRefreshTokens(userID int) error {
+	_, err := s.db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+func (s *postgresStore) CreatePasswordReset(pr PasswordReset) error {
+	_, err := s.db.Exec("INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		pr.UserID, pr.TokenHash, pr.ExpiresAt)
+	return err
+}
+
+func (s *postgresStore) FindPasswordResetByHash(hash string) (*PasswordReset, error) {
+	var pr PasswordReset
+	err := s.db.QueryRow(
+		"SELECT id, user_id, token_hash, expires_at, used_at FROM password_resets WHERE token_hash = $1 AND used_at IS NULL",
+		hash,
+	).Scan(&pr.ID, &pr.UserID, &pr.TokenHash, &pr.ExpiresAt, &pr.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+func (s *postgresStore) MarkPasswordResetUsed(id int) (bool, error) {
+	result, err := s.db.Exec("UPDATE password_resets SET used_at = NOW() WHERE id = $1 AND used_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (s *postgresStore) CreateSigningKey(k SigningKey) error {
+	_, err := s.db.Exec(
+		"INSERT INTO signing_keys (kid, private_key_pem, public_key_pem, created_at, is_current) VALUES ($1, $2, $3, $4, $5)",
+		k.Kid, k.PrivateKeyPEM, k.PublicKeyPEM, k.CreatedAt, k.IsCurrent,
+	)
+	return err
+}
+
+func (s *postgresStore) DemoteCurrentSigningKeys() error {
+	_, err := s.db.Exec("UPDATE signing_keys SET is_current = FALSE WHERE is_current = TRUE")
+	return err
+}
+
+func (s *postgresStore) CurrentSigningKey() (*SigningKey, error) {
+	var k SigningKey
+	err := s.db.QueryRow(
+		"SELECT kid, private_key_pem, public_key_pem FROM signing_keys WHERE is_current = TRUE ORDER BY created_at DESC LIMIT 1",
+	).Scan(&k.Kid, &k.PrivateKeyPEM, &k.PublicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *postgresStore) SigningKeyByKid(kid string) (*SigningKey, error) {
+	var k SigningKey
+	k.Kid = kid
+	err := s.db.QueryRow(
+		"SELECT private_key_pem, public_key_pem FROM signing_keys WHERE kid = $1 AND retired_at IS NULL", kid,
+	).Scan(&k.PrivateKeyPEM, &k.PublicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *postgresStore) ActiveSigningKeys() ([]SigningKey, error) {
+	rows, err := s.db.Query("SELECT kid, public_key_pem FROM signing_keys WHERE retired_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var k SigningKey
+		if err := rows.Scan(&k.Kid, &k.PublicKeyPEM); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresStore) CountCurrentSigningKeys() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM signing_keys WHERE is_current = TRUE").Scan(&count)
+	return count, err
+}
+
+func (s *postgresStore) CreateOAuthClient(c OAuthClient) error {
+	_, err := s.db.Exec(
+		"INSERT INTO oauth_clients (client_id, client_secret_hash, redirect_uris, allowed_scopes, name) VALUES ($1, $2, $3, $4, $5)",
+		c.ClientID, c.ClientSecretHash, c.RedirectURIs, c.AllowedScopes, c.Name,
+	)
+	return err
+}
+
+func (s *postgresStore) FindOAuthClient(clientID string) (*OAuthClient, error) {
+	var c OAuthClient
+	err := s.db.QueryRow(
+		"SELECT client_id, client_secret_hash, redirect_uris, allowed_scopes, name FROM oauth_clients WHERE client_id = $1",
+		clientID,
+	).Scan(&c.ClientID, &c.ClientSecretHash, &c.RedirectURIs, &c.AllowedScopes, &c.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *postgresStore) CreateOAuthCode(c OAuthCode) error {
+	_, err := s.db.Exec(
+		`INSERT INTO oauth_codes (code, client_id, user_id, redirect_uri, scopes, code_challenge, nonce, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		c.Code, c.ClientID, c.UserID, c.RedirectURI, c.Scopes, c.CodeChallenge, c.Nonce, c.ExpiresAt,
+	)
+	return err
+}
+
+func (s *postgresStore) FindOAuthCode(code, clientID string) (*OAuthCode, error) {
+	var c OAuthCode
+	err := s.db.QueryRow(
+		"SELECT code, client_id, user_id, redirect_uri, scopes, code_challenge, nonce, expires_at, used_at FROM oauth_codes WHERE code = $1 AND client_id = $2",
+		code, clientID,
+	).Scan(&c.Code, &c.ClientID, &c.UserID, &c.RedirectURI, &c.Scopes, &c.CodeChallenge, &c.Nonce, &c.ExpiresAt, &c.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *postgresStore) MarkOAuthCodeUsed(code string) (bool, error) {
+	result, err := s.db.Exec("UPDATE oauth_codes SET used_at = NOW() WHERE code = $1 AND used_at IS NULL", code)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
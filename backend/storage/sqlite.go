@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLite(path string) (Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := runMigrations(db, sqliteMigrations, "migrations/sqlite", func(n int) string { return "?" }); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Close() error { return s.db.Close() }
+
+func (s *sqliteStore) FindUserByEmail(email string) (*User, error) {
+	var u User
+	err := s.db.QueryRow("SELECT id, email, password, role, totp_secret, totp_confirmed, scopes FROM users WHERE email = ?", email).
+		Scan(&u.ID, &u.Email, &u.Password, &u.Role, &u.TotpSecret, &u.TotpConfirmed, &u.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *sqliteStore) FindUserByID(id int) (*User, error) {
+	var u User
+	err := s.db.QueryRow("SELECT id, email, password, role, totp_secret, totp_confirmed, scopes FROM users WHERE id = ?", id).
+		Scan(&u.ID, &u.Email, &u.Password, &u.Role, &u.TotpSecret, &u.TotpConfirmed, &u.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *sqliteStore) CreateUser(user User) (int, error) {
+	result, err := s.db.Exec("INSERT INTO users (email, password, role, scopes) VALUES (?, ?, ?, ?)",
+		user.Email, user.Password, user.Role, user.Scopes)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqliteStore) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT id, email, role FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqliteStore) UpdateUser(user User) error {
+	_, err := s.db.Exec("UPDATE users SET email = ?, password = ?, role = ?, scopes = ? WHERE id = ?",
+		user.Email, user.Password, user.Role, user.Scopes, user.ID)
+	return err
+}
+
+func (s *sqliteStore) UpdateUserPassword(id int, passwordHash string) error {
+	_, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, id)
+	return err
+}
+
+func (s *sqliteStore) UpdateUserScopes(id int, scopes string) (bool, error) {
+	result, err := s.db.Exec("UPDATE users SET scopes = ? WHERE id = ?", scopes, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (s *sqliteStore) SetUserTotp(id int, secret string, confirmed bool) error {
+	_, err := s.db.Exec("UPDATE users SET totp_secret = ?, totp_confirmed = ? WHERE id = ?", secret, confirmed, id)
+	return err
+}
+
+func (s *sqliteStore) CreateRecoveryCodes(userID int, hashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) UnusedRecoveryCodes(userID int) ([]RecoveryCode, error) {
+	rows, err := s.db.Query("SELECT id, user_id, code_hash FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []RecoveryCode
+	for rows.Next() {
+		var c RecoveryCode
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Hash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+func (s *sqliteStore) MarkRecoveryCodeUsed(id int) error {
+	_, err := s.db.Exec("UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+func (s *sqliteStore) CreateRefreshToken(t RefreshToken) (string, error) {
+	_, err := s.db.Exec(
+		`INSERT INTO refresh_tokens (user_id, token_hash, parent_id, issued_at, expires_at, user_agent, ip)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.UserID, t.TokenHash, t.ParentID, t.IssuedAt, t.ExpiresAt, t.UserAgent, t.IP,
+	)
+	return t.TokenHash, err
+}
+
+func (s *sqliteStore) FindRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	var t RefreshToken
+	err := s.db.QueryRow(
+		"SELECT id, user_id, token_hash, parent_id, issued_at, expires_at, revoked_at, user_agent, ip FROM refresh_tokens WHERE token_hash = ?",
+		hash,
+	).Scan(&t.ID, &t.UserID, &t.TokenHash, &t.ParentID, &t.IssuedAt, &t.ExpiresAt, &t.RevokedAt, &t.UserAgent, &t.IP)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *sqliteStore) RevokeRefreshToken(id int) error {
+	_, err := s.db.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+func (s *sqliteStore) RevokeAllRefreshTokens(userID int) error {
+	_, err := s.db.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", userID)
+	return err
+}
+
+func (s *sqliteStore) CreatePasswordReset(pr PasswordReset) error {
+	_, err := s.db.Exec("INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?, ?, ?)",
+		pr.UserID, pr.TokenHash, pr.ExpiresAt)
+	return err
+}
+
+func (s *sqliteStore) FindPasswordResetByHash(hash string) (*PasswordReset, error) {
+	var pr PasswordReset
+	err := s.db.QueryRow(
+		"SELECT id, user_id, token_hash, expires_at, used_at FROM password_resets WHERE token_hash = ? AND used_at IS NULL",
+		hash,
+	).Scan(&pr.ID, &pr.UserID, &pr.TokenHash, &pr.ExpiresAt, &pr.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+func (s *sqliteStore) MarkPasswordResetUsed(id int) (bool, error) {
+	result, err := s.db.Exec("UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE id = ? AND used_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (s *sqliteStore) CreateSigningKey(k SigningKey) error {
+	_, err := s.db.Exec(
+		"INSERT INTO signing_keys (kid, private_key_pem, public_key_pem, created_at, is_current) VALUES (?, ?, ?, ?, ?)",
+		k.Kid, k.PrivateKeyPEM, k.PublicKeyPEM, k.CreatedAt, k.IsCurrent,
+	)
+	return err
+}
+
+func (s *sqliteStore) DemoteCurrentSigningKeys() error {
+	_, err := s.db.Exec("UPDATE signing_keys SET is_current = 0 WHERE is_current = 1")
+	return err
+}
+
+func (s *sqliteStore) CurrentSigningKey() (*SigningKey, error) {
+	var k SigningKey
+	err := s.db.QueryRow(
+		"SELECT kid, private_key_pem, public_key_pem FROM signing_keys WHERE is_current = 1 ORDER BY created_at DESC LIMIT 1",
+	).Scan(&k.Kid, &k.PrivateKeyPEM, &k.PublicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *sqliteStore) SigningKeyByKid(kid string) (*SigningKey, error) {
+	var k SigningKey
+	k.Kid = kid
+	err := s.db.QueryRow(
+		"SELECT private_key_pem, public_key_pem FROM signing_keys WHERE kid = ? AND retired_at IS NULL", kid,
+	).Scan(&k.PrivateKeyPEM, &k.PublicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *sqliteStore) ActiveSigningKeys() ([]SigningKey, error) {
+	rows, err := s.db.Query("SELECT kid, public_key_pem FROM signing_keys WHERE retired_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var k SigningKey
+		if err := rows.Scan(&k.Kid, &k.PublicKeyPEM); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqliteStore) CountCurrentSigningKeys() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM signing_keys WHERE is_current = 1").Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) CreateOAuthClient(c OAuthClient) error {
+	_, err := s.db.Exec(
+		"INSERT INTO oauth_clients (client_id, client_secret_hash, redirect_uris, allowed_scopes, name) VALUES (?, ?, ?, ?, ?)",
+		c.ClientID, c.ClientSecretHash, c.RedirectURIs, c.AllowedScopes, c.Name,
+	)
+	return err
+}
+
+func (s *sqliteStore) FindOAuthClient(clientID string) (*OAuthClient, error) {
+	var c OAuthClient
+	err := s.db.QueryRow(
+		"SELECT client_id, client_secret_hash, redirect_uris, allowed_scopes, name FROM oauth_clients WHERE client_id = ?",
+		clientID,
+	).Scan(&c.ClientID, &c.ClientSecretHash, &c.RedirectURIs, &c.AllowedScopes, &c.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *sqliteStore) CreateOAuthCode(c OAuthCode) error {
+	_, err := s.db.Exec(
+		`INSERT INTO oauth_codes (code, client_id, user_id, redirect_uri, scopes, code_challenge, nonce, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.Code, c.ClientID, c.UserID, c.RedirectURI, c.Scopes, c.CodeChallenge, c.Nonce, c.ExpiresAt,
+	)
+	return err
+}
+
+func (s *sqliteStore) FindOAuthCode(code, clientID string) (*OAuthCode, error) {
+	var c OAuthCode
+	err := s.db.QueryRow(
+		"SELECT code, client_id, user_id, redirect_uri, scopes, code_challenge, nonce, expires_at, used_at FROM oauth_codes WHERE code = ? AND client_id = ?",
+		code, clientID,
+	).Scan(&c.Code, &c.ClientID, &c.UserID, &c.RedirectURI, &c.Scopes, &c.CodeChallenge, &c.Nonce, &c.ExpiresAt, &c.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *sqliteStore) MarkOAuthCodeUsed(code string) (bool, error) {
+	result, err := s.db.Exec("UPDATE oauth_codes SET used_at = CURRENT_TIMESTAMP WHERE code = ? AND used_at IS NULL", code)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
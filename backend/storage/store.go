@@ -0,0 +1,82 @@
+// Package storage hides the database behind a Store interface so handlers
+// take a struct receiver instead of reaching for a package-global *sql.DB,
+// and so sqlite and postgres can be swapped via DATABASE_URL without
+// touching handler code.
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Store is everything a handler needs from the database. It is deliberately
+// a thin wrapper around SQL rather than a generic repository: each method
+// maps to the query a handler actually runs today.
+type Store interface {
+	FindUserByEmail(email string) (*User, error)
+	FindUserByID(id int) (*User, error)
+	CreateUser(user User) (int, error)
+	ListUsers() ([]User, error)
+	UpdateUser(user User) error
+	UpdateUserPassword(id int, passwordHash string) error
+	UpdateUserScopes(id int, scopes string) (bool, error)
+	SetUserTotp(id int, secret string, confirmed bool) error
+
+	CreateRecoveryCodes(userID int, hashes []string) error
+	UnusedRecoveryCodes(userID int) ([]RecoveryCode, error)
+	MarkRecoveryCodeUsed(id int) error
+
+	CreateRefreshToken(t RefreshToken) (string, error)
+	FindRefreshTokenByHash(hash string) (*RefreshToken, error)
+	RevokeRefreshToken(id int) error
+	RevokeAllRefreshTokens(userID int) error
+
+	CreatePasswordReset(pr PasswordReset) error
+	FindPasswordResetByHash(hash string) (*PasswordReset, error)
+	MarkPasswordResetUsed(id int) (bool, error)
+
+	CreateSigningKey(k SigningKey) error
+	DemoteCurrentSigningKeys() error
+	CurrentSigningKey() (*SigningKey, error)
+	SigningKeyByKid(kid string) (*SigningKey, error)
+	ActiveSigningKeys() ([]SigningKey, error)
+	CountCurrentSigningKeys() (int, error)
+
+	CreateOAuthClient(c OAuthClient) error
+	FindOAuthClient(clientID string) (*OAuthClient, error)
+
+	CreateOAuthCode(c OAuthCode) error
+	FindOAuthCode(code, clientID string) (*OAuthCode, error)
+	MarkOAuthCodeUsed(code string) (bool, error)
+
+	Close() error
+}
+
+// Open selects and connects to a Store based on databaseURL's scheme
+// (sqlite:// or postgres://), then applies any outstanding migrations.
+// It refuses to return a Store if a migration fails to apply.
+func Open(databaseURL string) (Store, error) {
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+
+	switch strings.TrimSuffix(u.Scheme, ":") {
+	case "sqlite":
+		return openSQLite(sqliteDSN(u))
+	case "postgres", "postgresql":
+		return openPostgres(databaseURL)
+	default:
+		return nil, fmt.Errorf("unsupported DATABASE_URL scheme %q", u.Scheme)
+	}
+}
+
+// sqliteDSN turns sqlite:///path/to.db or sqlite://./relative.db into the
+// bare filesystem path go-sqlite3 expects.
+func sqliteDSN(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Host + u.Path
+}